@@ -0,0 +1,173 @@
+package locate
+
+import "math"
+
+// lmResult carries diagnostics out of a single levenbergMarquardt run.
+type lmResult struct {
+	iterations int
+	converged  bool
+	stepped    bool // whether at least one LM step actually improved the fit
+	finalError float64
+	residuals  []float64 // calculated minus measured distance, in fit-node order
+}
+
+// levenbergMarquardt fits position to nodes by minimizing the sum of
+// squared (optionally weighted) residuals between the calculated distance
+// from position to each node and that node's measured distance.
+//
+// Row i of the Jacobian is (position-node.Position)/||position-node.Position||,
+// the unit vector pointing from the node toward the current estimate, since
+// that's the gradient of calculated distance with respect to position. At
+// each step we solve (JᵀWJ + λI)Δ = -JᵀW r for the update Δ, growing λ by
+// 10x on a step that makes the fit worse (and retrying) and shrinking it by
+// 10x on a step that improves it, in the classic LM fashion.
+func levenbergMarquardt(nodes []Node, weights []float64, initialGuess Position, opts Options) (Position, lmResult) {
+	if weights == nil {
+		weights = make([]float64, len(nodes))
+		for i := range weights {
+			weights[i] = 1.0
+		}
+	}
+
+	position := initialGuess
+	lambda := opts.InitialLambda
+	currentError, currentResiduals := weightedSSE(position, nodes, weights)
+
+	result := lmResult{finalError: currentError, residuals: currentResiduals}
+
+	for iteration := 0; iteration < opts.MaxIterations; iteration++ {
+		result.iterations = iteration + 1
+
+		jacobian, residuals := buildJacobian(position, nodes)
+		delta, ok := solveNormalEquations(jacobian, residuals, weights, lambda)
+		if !ok {
+			// Singular system (e.g. degenerate node geometry); stop here.
+			break
+		}
+
+		candidate := Position{
+			X: position.X + delta[0],
+			Y: position.Y + delta[1],
+			Z: position.Z + delta[2],
+		}
+		candidateError, candidateResiduals := weightedSSE(candidate, nodes, weights)
+
+		if candidateError < currentError {
+			position = candidate
+			currentError = candidateError
+			currentResiduals = candidateResiduals
+			result.finalError = currentError
+			result.residuals = currentResiduals
+			result.stepped = true
+			lambda /= 10
+
+			stepSize := math.Sqrt(delta[0]*delta[0] + delta[1]*delta[1] + delta[2]*delta[2])
+			if stepSize < opts.ConvergenceThreshold {
+				result.converged = true
+				break
+			}
+		} else {
+			lambda *= 10
+		}
+	}
+
+	return position, result
+}
+
+// buildJacobian returns the Jacobian of calculated distance with respect to
+// position (one row per node) along with the current unweighted residuals.
+func buildJacobian(position Position, nodes []Node) ([][3]float64, []float64) {
+	jacobian := make([][3]float64, len(nodes))
+	residuals := make([]float64, len(nodes))
+
+	for i, n := range nodes {
+		dx := position.X - n.Position.X
+		dy := position.Y - n.Position.Y
+		dz := position.Z - n.Position.Z
+		calculated := math.Sqrt(dx*dx + dy*dy + dz*dz)
+
+		residuals[i] = calculated - n.Distance
+		if calculated > 0 {
+			jacobian[i] = [3]float64{dx / calculated, dy / calculated, dz / calculated}
+		}
+	}
+
+	return jacobian, residuals
+}
+
+// solveNormalEquations solves (JᵀWJ + λI)Δ = -JᵀWr for Δ.
+func solveNormalEquations(jacobian [][3]float64, residuals, weights []float64, lambda float64) ([3]float64, bool) {
+	var jtj [3][3]float64
+	var jtr [3]float64
+
+	for i, row := range jacobian {
+		w := weights[i]
+		for a := 0; a < 3; a++ {
+			jtr[a] -= w * row[a] * residuals[i]
+			for b := 0; b < 3; b++ {
+				jtj[a][b] += w * row[a] * row[b]
+			}
+		}
+	}
+	for a := 0; a < 3; a++ {
+		jtj[a][a] += lambda
+	}
+
+	return solve3x3(jtj, jtr)
+}
+
+// solve3x3 solves Ax = b via Gaussian elimination with partial pivoting.
+func solve3x3(a [3][3]float64, b [3]float64) ([3]float64, bool) {
+	const epsilon = 1e-12
+
+	for col := 0; col < 3; col++ {
+		pivotRow := col
+		pivotMagnitude := math.Abs(a[col][col])
+		for row := col + 1; row < 3; row++ {
+			if mag := math.Abs(a[row][col]); mag > pivotMagnitude {
+				pivotRow = row
+				pivotMagnitude = mag
+			}
+		}
+		if pivotMagnitude < epsilon {
+			return [3]float64{}, false
+		}
+		if pivotRow != col {
+			a[col], a[pivotRow] = a[pivotRow], a[col]
+			b[col], b[pivotRow] = b[pivotRow], b[col]
+		}
+
+		for row := col + 1; row < 3; row++ {
+			factor := a[row][col] / a[col][col]
+			for k := col; k < 3; k++ {
+				a[row][k] -= factor * a[col][k]
+			}
+			b[row] -= factor * b[col]
+		}
+	}
+
+	var x [3]float64
+	for row := 2; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < 3; k++ {
+			sum -= a[row][k] * x[k]
+		}
+		x[row] = sum / a[row][row]
+	}
+	return x, true
+}
+
+func weightedSSE(position Position, nodes []Node, weights []float64) (float64, []float64) {
+	residuals := make([]float64, len(nodes))
+	var sse float64
+	for i, n := range nodes {
+		dx := position.X - n.Position.X
+		dy := position.Y - n.Position.Y
+		dz := position.Z - n.Position.Z
+		calculated := math.Sqrt(dx*dx + dy*dy + dz*dz)
+		r := calculated - n.Distance
+		residuals[i] = r
+		sse += weights[i] * r * r
+	}
+	return sse, residuals
+}
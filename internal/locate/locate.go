@@ -0,0 +1,235 @@
+// Package locate implements trilateration of a target position from a set
+// of fixed anchor nodes and their measured distances to the target.
+//
+// Unlike a plain least-squares fit, Solve is robust to a handful of bad
+// distance readings: it runs Levenberg-Marquardt inside a RANSAC outer loop
+// to find a consensus set of inlier nodes, then refines the estimate over
+// that consensus set with iteratively reweighted least squares (IRLS) so
+// that remaining noisy-but-not-outlier readings are down-weighted rather
+// than trusted equally. It has no dependency on the transport (WebSocket,
+// MQTT, ...) that produced the readings, so it can be exercised directly.
+package locate
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Position is a point in 3D space.
+type Position struct {
+	X float64
+	Y float64
+	Z float64
+}
+
+// Node is a single anchor's measured distance to the target.
+type Node struct {
+	ID       string
+	Position Position
+	Distance float64
+}
+
+// WeightFunc maps a residual (calculated distance minus measured distance,
+// in meters) to a weight in [0, 1] used by the IRLS refinement pass.
+type WeightFunc func(residual float64) float64
+
+// Options controls the solver. A zero Options uses sane defaults; see
+// DefaultOptions.
+type Options struct {
+	// MaxIterations bounds the Levenberg-Marquardt inner loop.
+	MaxIterations int
+	// InitialLambda is the starting LM damping factor.
+	InitialLambda float64
+	// ConvergenceThreshold stops LM once the step size drops below it.
+	ConvergenceThreshold float64
+
+	// RANSACIterations is the number of random 3-node subsets to try.
+	// Zero disables RANSAC and fits all nodes directly with IRLS.
+	RANSACIterations int
+	// InlierThreshold is the maximum |residual| in meters for a node to
+	// count toward a RANSAC candidate's consensus set.
+	InlierThreshold float64
+
+	// Weighting reweights residuals between IRLS passes. Defaults to
+	// Huber with a 0.5m transition if nil.
+	Weighting WeightFunc
+	// IRLSIterations bounds the number of reweighting passes.
+	IRLSIterations int
+}
+
+// DefaultOptions returns the Options used when Solve is called with a zero
+// value.
+func DefaultOptions() Options {
+	return Options{
+		MaxIterations:        50,
+		InitialLambda:        1e-2,
+		ConvergenceThreshold: 1e-4,
+		RANSACIterations:     200,
+		InlierThreshold:      1.5,
+		Weighting:            Huber(0.5),
+		IRLSIterations:       4,
+	}
+}
+
+func (o Options) withDefaults() Options {
+	d := DefaultOptions()
+	if o.MaxIterations > 0 {
+		d.MaxIterations = o.MaxIterations
+	}
+	if o.InitialLambda > 0 {
+		d.InitialLambda = o.InitialLambda
+	}
+	if o.ConvergenceThreshold > 0 {
+		d.ConvergenceThreshold = o.ConvergenceThreshold
+	}
+	if o.RANSACIterations != 0 {
+		d.RANSACIterations = o.RANSACIterations
+	}
+	if o.InlierThreshold > 0 {
+		d.InlierThreshold = o.InlierThreshold
+	}
+	if o.Weighting != nil {
+		d.Weighting = o.Weighting
+	}
+	if o.IRLSIterations > 0 {
+		d.IRLSIterations = o.IRLSIterations
+	}
+	return d
+}
+
+// NodeResidual records how well a solved position agrees with a single
+// node's measurement, so callers can show which nodes were trusted.
+type NodeResidual struct {
+	NodeID   string
+	Residual float64 // calculated distance minus measured distance, meters
+	Weight   float64 // final IRLS weight, 1.0 = fully trusted
+	Inlier   bool
+}
+
+// Diagnostics describes how a Solve call arrived at its answer.
+type Diagnostics struct {
+	Iterations int
+	Converged  bool
+	FinalError float64 // sum of squared weighted residuals at the solution
+	Residuals  []NodeResidual
+}
+
+// Solve estimates the target position from node measurements, starting the
+// search from initialGuess. With fewer than 3 nodes there isn't enough
+// information to trilaterate, so initialGuess is returned unchanged.
+func Solve(nodes []Node, initialGuess Position, opts Options) (Position, Diagnostics) {
+	opts = opts.withDefaults()
+	if len(nodes) < 3 {
+		return initialGuess, Diagnostics{}
+	}
+	if opts.RANSACIterations > 0 && len(nodes) > 3 {
+		return solveRANSAC(nodes, initialGuess, opts)
+	}
+	return solveIRLS(nodes, nodes, initialGuess, opts)
+}
+
+// solveRANSAC repeatedly fits a 3-node subset, scores it by how many of the
+// remaining nodes it agrees with, and keeps the best-supported consensus
+// set. The final position is then refined over that consensus set with
+// IRLS so near-inliers are down-weighted rather than trusted fully.
+func solveRANSAC(nodes []Node, initialGuess Position, opts Options) (Position, Diagnostics) {
+	rng := rand.New(rand.NewSource(1))
+
+	var bestInliers []Node
+	bestPosition := initialGuess
+
+	for i := 0; i < opts.RANSACIterations; i++ {
+		sample := sampleNodes(rng, nodes, 3)
+		candidate, result := levenbergMarquardt(sample, nil, initialGuess, opts)
+		if !result.stepped {
+			// No LM step improved on initialGuess (e.g. a degenerate,
+			// near-collinear 3-node sample); the candidate is worthless,
+			// so don't let it compete for RANSAC consensus.
+			continue
+		}
+
+		var inliers []Node
+		for _, n := range nodes {
+			if math.Abs(residual(candidate, n)) <= opts.InlierThreshold {
+				inliers = append(inliers, n)
+			}
+		}
+		if len(inliers) > len(bestInliers) {
+			bestInliers = inliers
+			bestPosition = candidate
+		}
+	}
+
+	if len(bestInliers) < 3 {
+		// No candidate found enough support; fall back to fitting everything.
+		bestInliers = nodes
+	}
+
+	return solveIRLS(bestInliers, nodes, bestPosition, opts)
+}
+
+// solveIRLS refines position over fitNodes, reweighting residuals between
+// passes, then reports per-node diagnostics against allNodes so callers can
+// see how every node (including ones RANSAC excluded) agrees with the
+// final answer.
+func solveIRLS(fitNodes, allNodes []Node, initialGuess Position, opts Options) (Position, Diagnostics) {
+	position := initialGuess
+	weights := make([]float64, len(fitNodes))
+	for i := range weights {
+		weights[i] = 1.0
+	}
+
+	var result lmResult
+	for pass := 0; pass < opts.IRLSIterations; pass++ {
+		position, result = levenbergMarquardt(fitNodes, weights, position, opts)
+		for i, r := range result.residuals {
+			weights[i] = opts.Weighting(r)
+		}
+	}
+
+	inlierSet := make(map[string]bool, len(fitNodes))
+	for _, n := range fitNodes {
+		inlierSet[n.ID] = true
+	}
+
+	residuals := make([]NodeResidual, len(allNodes))
+	for i, n := range allNodes {
+		r := residual(position, n)
+		residuals[i] = NodeResidual{
+			NodeID:   n.ID,
+			Residual: r,
+			Weight:   opts.Weighting(r),
+			Inlier:   inlierSet[n.ID],
+		}
+	}
+
+	return position, Diagnostics{
+		Iterations: result.iterations,
+		Converged:  result.converged,
+		FinalError: result.finalError,
+		Residuals:  residuals,
+	}
+}
+
+func sampleNodes(rng *rand.Rand, nodes []Node, k int) []Node {
+	if k >= len(nodes) {
+		return nodes
+	}
+	idx := rng.Perm(len(nodes))[:k]
+	sample := make([]Node, k)
+	for i, j := range idx {
+		sample[i] = nodes[j]
+	}
+	return sample
+}
+
+// residual returns the signed difference between the calculated distance
+// from p to n and n's measured distance, in meters: positive means the
+// position is farther from n than measured, negative means closer.
+func residual(p Position, n Node) float64 {
+	dx := p.X - n.Position.X
+	dy := p.Y - n.Position.Y
+	dz := p.Z - n.Position.Z
+	calculated := math.Sqrt(dx*dx + dy*dy + dz*dz)
+	return calculated - n.Distance
+}
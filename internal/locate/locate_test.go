@@ -0,0 +1,97 @@
+package locate
+
+import (
+	"math"
+	"testing"
+)
+
+// nodesForPosition returns Nodes placed at fixed anchor positions, each
+// with its Distance set to the exact range to target so a perfect solver
+// should recover target exactly.
+func nodesForPosition(target Position, anchors []Position) []Node {
+	nodes := make([]Node, len(anchors))
+	for i, a := range anchors {
+		nodes[i] = Node{
+			ID:       string(rune('A' + i)),
+			Position: a,
+			Distance: distance(target, a),
+		}
+	}
+	return nodes
+}
+
+func distance(p, q Position) float64 {
+	dx := p.X - q.X
+	dy := p.Y - q.Y
+	dz := p.Z - q.Z
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+func TestSolveConvergesOnKnownGeometry(t *testing.T) {
+	anchors := []Position{
+		{X: 0, Y: 0, Z: 0},
+		{X: 10, Y: 0, Z: 0},
+		{X: 0, Y: 10, Z: 0},
+		{X: 10, Y: 10, Z: 3},
+	}
+	want := Position{X: 4, Y: 6, Z: 1}
+	nodes := nodesForPosition(want, anchors)
+
+	got, diag := Solve(nodes, Position{}, Options{})
+
+	if !diag.Converged {
+		t.Fatalf("expected convergence, got diagnostics %+v", diag)
+	}
+	if d := distance(got, want); d > 1e-3 {
+		t.Errorf("Solve() = %+v, want %+v (off by %.6fm)", got, want, d)
+	}
+	for _, r := range diag.Residuals {
+		if !r.Inlier {
+			t.Errorf("node %s unexpectedly marked as outlier with clean data", r.NodeID)
+		}
+	}
+}
+
+func TestSolveRejectsOutlier(t *testing.T) {
+	anchors := []Position{
+		{X: 0, Y: 0, Z: 0},
+		{X: 10, Y: 0, Z: 0},
+		{X: 0, Y: 10, Z: 0},
+		{X: 10, Y: 10, Z: 0},
+		{X: 5, Y: 5, Z: 10},
+	}
+	want := Position{X: 5, Y: 5, Z: 2}
+	nodes := nodesForPosition(want, anchors)
+
+	// Corrupt one measurement far beyond plausible RSSI noise.
+	nodes[2].Distance += 25
+
+	got, diag := Solve(nodes, Position{}, Options{})
+
+	if d := distance(got, want); d > 0.5 {
+		t.Errorf("Solve() = %+v, want close to %+v (off by %.6fm), outlier not rejected", got, want, d)
+	}
+
+	for _, r := range diag.Residuals {
+		if r.NodeID == nodes[2].ID && r.Inlier {
+			t.Errorf("corrupted node %s was kept as an inlier", r.NodeID)
+		}
+	}
+}
+
+func TestSolveReturnsGuessWithFewerThanThreeNodes(t *testing.T) {
+	guess := Position{X: 1, Y: 2, Z: 3}
+	nodes := []Node{
+		{ID: "A", Position: Position{X: 0, Y: 0, Z: 0}, Distance: 5},
+		{ID: "B", Position: Position{X: 10, Y: 0, Z: 0}, Distance: 5},
+	}
+
+	got, diag := Solve(nodes, guess, Options{})
+
+	if got != guess {
+		t.Errorf("Solve() = %+v, want unchanged guess %+v", got, guess)
+	}
+	if diag.Converged || diag.Iterations != 0 {
+		t.Errorf("expected zero-value diagnostics, got %+v", diag)
+	}
+}
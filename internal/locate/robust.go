@@ -0,0 +1,32 @@
+package locate
+
+import "math"
+
+// Huber returns a WeightFunc implementing Huber's weighting: residuals
+// within delta meters are fully trusted, and residuals beyond it are
+// down-weighted proportionally to delta/|residual| so a single bad reading
+// can't dominate the fit.
+func Huber(delta float64) WeightFunc {
+	return func(residual float64) float64 {
+		abs := math.Abs(residual)
+		if abs <= delta {
+			return 1.0
+		}
+		return delta / abs
+	}
+}
+
+// Tukey returns a WeightFunc implementing Tukey's biweight: residuals
+// beyond c meters are weighted to zero (treated as outliers) instead of
+// merely down-weighted, which makes it more aggressive than Huber at
+// rejecting gross errors.
+func Tukey(c float64) WeightFunc {
+	return func(residual float64) float64 {
+		abs := math.Abs(residual)
+		if abs >= c {
+			return 0.0
+		}
+		u := residual / c
+		return (1 - u*u) * (1 - u*u)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the parameters that used to be compiled-in constants: the
+// listen port, default calibration, trilateration solver hyperparameters,
+// stale-node timeout, and status-print interval. The effective value of
+// each field comes from, in increasing priority: defaultConfig, an
+// optional -config YAML file, environment variables, and explicitly-passed
+// CLI flags. DefaultCalibration and Trilateration can be hot-reloaded on
+// SIGHUP (see watchSIGHUP); the rest are only read once at startup.
+type Config struct {
+	ServerPort          int
+	DefaultCalibration  CalibrationParams
+	Trilateration       TrilaterationConfig
+	StaleNodeTimeout    time.Duration
+	StatusPrintInterval time.Duration
+}
+
+// TrilaterationConfig exposes the LM hyperparameters worth tuning without a
+// recompile. RANSAC iterations, inlier threshold, IRLS passes, and the
+// weighting function stay at internal/locate's defaults.
+type TrilaterationConfig struct {
+	MaxIterations        int
+	LearningRate         float64 // locate.Options.InitialLambda, the LM damping factor
+	ConvergenceThreshold float64
+}
+
+// defaultConfig returns the values this server shipped with before they
+// became configurable.
+func defaultConfig() Config {
+	return Config{
+		ServerPort:         3200,
+		DefaultCalibration: CalibrationParams{RSSIAt1m: -60.0, PathLoss: 2.0},
+		Trilateration: TrilaterationConfig{
+			MaxIterations:        50,
+			LearningRate:         1e-2,
+			ConvergenceThreshold: 1e-4,
+		},
+		StaleNodeTimeout:    60 * time.Second,
+		StatusPrintInterval: 30 * time.Second,
+	}
+}
+
+var (
+	configMutex sync.RWMutex
+	config      = defaultConfig()
+
+	configPath             string
+	printExampleConfigFlag bool
+
+	flagServerPort               int
+	flagStaleNodeTimeout         time.Duration
+	flagStatusPrintInterval      time.Duration
+	flagDefaultRSSIAt1m          float64
+	flagDefaultPathLoss          float64
+	flagTrilMaxIterations        int
+	flagTrilLearningRate         float64
+	flagTrilConvergenceThreshold float64
+)
+
+// registerConfigFlags registers -config/-example-config and a per-field
+// override flag for every Config value. Call before flag.Parse.
+func registerConfigFlags() {
+	flag.StringVar(&configPath, "config", "", "path to a YAML config file (see -example-config)")
+	flag.BoolVar(&printExampleConfigFlag, "example-config", false, "print a commented example config file to stdout and exit")
+
+	d := defaultConfig()
+	flag.IntVar(&flagServerPort, "port", d.ServerPort, "HTTP listen port")
+	flag.DurationVar(&flagStaleNodeTimeout, "stale-node-timeout", d.StaleNodeTimeout, "how long since last-seen before a connected node is reported stale")
+	flag.DurationVar(&flagStatusPrintInterval, "status-print-interval", d.StatusPrintInterval, "how often to log a summary of all known nodes")
+	flag.Float64Var(&flagDefaultRSSIAt1m, "default-rssi-at-1m", d.DefaultCalibration.RSSIAt1m, "default calibration: RSSI at 1 meter")
+	flag.Float64Var(&flagDefaultPathLoss, "default-path-loss", d.DefaultCalibration.PathLoss, "default calibration: path loss exponent")
+	flag.IntVar(&flagTrilMaxIterations, "trilateration-max-iterations", d.Trilateration.MaxIterations, "LM solver max iterations")
+	flag.Float64Var(&flagTrilLearningRate, "trilateration-learning-rate", d.Trilateration.LearningRate, "LM solver initial damping factor (learning rate)")
+	flag.Float64Var(&flagTrilConvergenceThreshold, "trilateration-convergence-threshold", d.Trilateration.ConvergenceThreshold, "LM solver convergence threshold")
+}
+
+// currentConfig returns a copy of the live config, safe for concurrent use.
+func currentConfig() Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return config
+}
+
+// setConfig replaces the live config under lock.
+func setConfig(cfg Config) {
+	configMutex.Lock()
+	config = cfg
+	configMutex.Unlock()
+}
+
+// loadConfig builds the effective Config from defaultConfig, an optional
+// -config file, environment variables, and explicitly-passed CLI flags, in
+// that increasing order of priority. It must run after flag.Parse, since it
+// uses flag.Visit to tell an explicitly-passed flag from one left at its
+// zero-value default.
+func loadConfig() (Config, error) {
+	cfg := defaultConfig()
+
+	if configPath != "" {
+		if err := applyConfigFile(&cfg, configPath); err != nil {
+			return Config{}, err
+		}
+	}
+
+	applyConfigEnv(&cfg)
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	applyConfigFlags(&cfg, set)
+
+	return cfg, nil
+}
+
+// fileConfig mirrors Config as read from a YAML file. Every field is a
+// pointer (or, for durations, a string parsed with time.ParseDuration) so
+// an absent key means "don't override", the same optionality the env var
+// and CLI flag layers have.
+type fileConfig struct {
+	ServerPort          *int                     `yaml:"server_port"`
+	DefaultCalibration  *fileCalibrationParams   `yaml:"default_calibration"`
+	Trilateration       *fileTrilaterationConfig `yaml:"trilateration"`
+	StaleNodeTimeout    string                   `yaml:"stale_node_timeout"`
+	StatusPrintInterval string                   `yaml:"status_print_interval"`
+}
+
+type fileCalibrationParams struct {
+	RSSIAt1m *float64 `yaml:"rssi_at_1m"`
+	PathLoss *float64 `yaml:"path_loss"`
+}
+
+type fileTrilaterationConfig struct {
+	MaxIterations        *int     `yaml:"max_iterations"`
+	LearningRate         *float64 `yaml:"learning_rate"`
+	ConvergenceThreshold *float64 `yaml:"convergence_threshold"`
+}
+
+// applyConfigFile overlays the fields present in the YAML file at path
+// onto cfg.
+func applyConfigFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	var fc fileConfig
+	if err := yaml.Unmarshal(data, &fc); err != nil {
+		return fmt.Errorf("parsing config file: %w", err)
+	}
+
+	if fc.ServerPort != nil {
+		cfg.ServerPort = *fc.ServerPort
+	}
+	if fc.DefaultCalibration != nil {
+		if fc.DefaultCalibration.RSSIAt1m != nil {
+			cfg.DefaultCalibration.RSSIAt1m = *fc.DefaultCalibration.RSSIAt1m
+		}
+		if fc.DefaultCalibration.PathLoss != nil {
+			cfg.DefaultCalibration.PathLoss = *fc.DefaultCalibration.PathLoss
+		}
+	}
+	if fc.Trilateration != nil {
+		if fc.Trilateration.MaxIterations != nil {
+			cfg.Trilateration.MaxIterations = *fc.Trilateration.MaxIterations
+		}
+		if fc.Trilateration.LearningRate != nil {
+			cfg.Trilateration.LearningRate = *fc.Trilateration.LearningRate
+		}
+		if fc.Trilateration.ConvergenceThreshold != nil {
+			cfg.Trilateration.ConvergenceThreshold = *fc.Trilateration.ConvergenceThreshold
+		}
+	}
+	if fc.StaleNodeTimeout != "" {
+		d, err := time.ParseDuration(fc.StaleNodeTimeout)
+		if err != nil {
+			return fmt.Errorf("parsing stale_node_timeout: %w", err)
+		}
+		cfg.StaleNodeTimeout = d
+	}
+	if fc.StatusPrintInterval != "" {
+		d, err := time.ParseDuration(fc.StatusPrintInterval)
+		if err != nil {
+			return fmt.Errorf("parsing status_print_interval: %w", err)
+		}
+		cfg.StatusPrintInterval = d
+	}
+	return nil
+}
+
+// applyConfigEnv overlays ESP_-prefixed environment variables onto cfg. An
+// unparsable value is logged and otherwise ignored, leaving the
+// lower-priority value in place.
+func applyConfigEnv(cfg *Config) {
+	envInt("ESP_SERVER_PORT", &cfg.ServerPort)
+	envFloat("ESP_DEFAULT_RSSI_AT_1M", &cfg.DefaultCalibration.RSSIAt1m)
+	envFloat("ESP_DEFAULT_PATH_LOSS", &cfg.DefaultCalibration.PathLoss)
+	envInt("ESP_TRILATERATION_MAX_ITERATIONS", &cfg.Trilateration.MaxIterations)
+	envFloat("ESP_TRILATERATION_LEARNING_RATE", &cfg.Trilateration.LearningRate)
+	envFloat("ESP_TRILATERATION_CONVERGENCE_THRESHOLD", &cfg.Trilateration.ConvergenceThreshold)
+	envDuration("ESP_STALE_NODE_TIMEOUT", &cfg.StaleNodeTimeout)
+	envDuration("ESP_STATUS_PRINT_INTERVAL", &cfg.StatusPrintInterval)
+}
+
+func envInt(name string, dst *int) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		logger.Warn("invalid integer env var", "name", name, "value", v, "error", err)
+		return
+	}
+	*dst = n
+}
+
+func envFloat(name string, dst *float64) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		logger.Warn("invalid float env var", "name", name, "value", v, "error", err)
+		return
+	}
+	*dst = f
+}
+
+func envDuration(name string, dst *time.Duration) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		logger.Warn("invalid duration env var", "name", name, "value", v, "error", err)
+		return
+	}
+	*dst = d
+}
+
+// applyConfigFlags overlays onto cfg only the flags present in set, i.e.
+// those the user actually passed on the command line.
+func applyConfigFlags(cfg *Config, set map[string]bool) {
+	if set["port"] {
+		cfg.ServerPort = flagServerPort
+	}
+	if set["default-rssi-at-1m"] {
+		cfg.DefaultCalibration.RSSIAt1m = flagDefaultRSSIAt1m
+	}
+	if set["default-path-loss"] {
+		cfg.DefaultCalibration.PathLoss = flagDefaultPathLoss
+	}
+	if set["trilateration-max-iterations"] {
+		cfg.Trilateration.MaxIterations = flagTrilMaxIterations
+	}
+	if set["trilateration-learning-rate"] {
+		cfg.Trilateration.LearningRate = flagTrilLearningRate
+	}
+	if set["trilateration-convergence-threshold"] {
+		cfg.Trilateration.ConvergenceThreshold = flagTrilConvergenceThreshold
+	}
+	if set["stale-node-timeout"] {
+		cfg.StaleNodeTimeout = flagStaleNodeTimeout
+	}
+	if set["status-print-interval"] {
+		cfg.StatusPrintInterval = flagStatusPrintInterval
+	}
+}
+
+// watchSIGHUP reloads calibration and trilateration parameters from
+// -config on SIGHUP, without restarting: node connections and positions
+// are untouched. It's a no-op loop if -config wasn't set, since
+// reloadConfig then just reapplies env vars/flags on top of the defaults.
+func watchSIGHUP() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	for range sighup {
+		reloadConfig()
+	}
+}
+
+// reloadConfig re-derives the config and updates the live
+// DefaultCalibration/Trilateration values in place, leaving ServerPort,
+// StaleNodeTimeout, and StatusPrintInterval at whatever they were set to
+// at startup.
+func reloadConfig() {
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Error("error reloading config on SIGHUP", "error", err)
+		return
+	}
+
+	configMutex.Lock()
+	config.DefaultCalibration = cfg.DefaultCalibration
+	config.Trilateration = cfg.Trilateration
+	configMutex.Unlock()
+
+	nodesMutex.Lock()
+	calibration["default"] = cfg.DefaultCalibration
+	nodesMutex.Unlock()
+
+	logger.Info("reloaded calibration and trilateration config on SIGHUP",
+		"rssi_at_1m", cfg.DefaultCalibration.RSSIAt1m, "path_loss", cfg.DefaultCalibration.PathLoss,
+		"max_iterations", cfg.Trilateration.MaxIterations, "learning_rate", cfg.Trilateration.LearningRate,
+		"convergence_threshold", cfg.Trilateration.ConvergenceThreshold)
+}
+
+// exampleConfigTemplate is printed by -example-config. Every field is
+// optional: a key left out keeps its default, or whatever a CLI flag / env
+// var sets.
+const exampleConfigTemplate = `# Example esp trilateration server config. Pass its path via -config.
+# Every field below is optional and falls back to its compiled-in default,
+# or to a CLI flag / env var override if one is set.
+
+# HTTP listen port.
+server_port: 3200
+
+# Calibration applied to a node before /calibrate has been called for it.
+default_calibration:
+  rssi_at_1m: -60.0
+  path_loss: 2.0
+
+# Levenberg-Marquardt trilateration solver hyperparameters. RANSAC
+# iterations, inlier threshold, and IRLS passes aren't configurable yet and
+# stay at internal/locate's defaults.
+trilateration:
+  max_iterations: 50
+  learning_rate: 0.01
+  convergence_threshold: 0.0001
+
+# How long since a node's last message before its status is reported as
+# stale instead of connected.
+stale_node_timeout: 60s
+
+# How often to log a summary of all known nodes.
+status_print_interval: 30s
+`
+
+// writeExampleConfig prints exampleConfigTemplate to stdout, for users to
+// redirect into a file: esp-server -example-config > config.yaml
+func writeExampleConfig() {
+	fmt.Print(exampleConfigTemplate)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sseEvent is a single frame pushed to /events subscribers.
+type sseEvent struct {
+	name string
+	data []byte
+}
+
+// eventSubscriberBufferSize bounds how far a subscriber can fall behind
+// before it's considered a slow consumer and evicted.
+const eventSubscriberBufferSize = 16
+
+var (
+	eventSubscribers      = make(map[chan sseEvent]struct{})
+	eventSubscribersMutex sync.Mutex
+)
+
+// subscribeEvents registers a new SSE subscriber channel.
+func subscribeEvents() chan sseEvent {
+	ch := make(chan sseEvent, eventSubscriberBufferSize)
+	eventSubscribersMutex.Lock()
+	eventSubscribers[ch] = struct{}{}
+	eventSubscribersMutex.Unlock()
+	return ch
+}
+
+// unsubscribeEvents removes and closes a subscriber channel.
+func unsubscribeEvents(ch chan sseEvent) {
+	eventSubscribersMutex.Lock()
+	if _, ok := eventSubscribers[ch]; ok {
+		delete(eventSubscribers, ch)
+		close(ch)
+	}
+	eventSubscribersMutex.Unlock()
+}
+
+// broadcastEvent fans payload out to every subscriber as an SSE frame
+// without blocking the caller. A subscriber whose buffer is full is
+// dropped rather than allowed to stall the broadcast.
+func broadcastEvent(name string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		logger.Error("error encoding event", "event", name, "error", err)
+		return
+	}
+	event := sseEvent{name: name, data: data}
+
+	eventSubscribersMutex.Lock()
+	defer eventSubscribersMutex.Unlock()
+	for ch := range eventSubscribers {
+		select {
+		case ch <- event:
+		default:
+			logger.Warn("dropping slow SSE subscriber")
+			delete(eventSubscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// NodeEvent reports a node connecting, disconnecting, or moving, for the
+// "node" SSE event.
+type NodeEvent struct {
+	NodeID   string   `json:"nodeId"`
+	Status   string   `json:"status"` // "connected", "disconnected", "position-changed"
+	Position Position `json:"position"`
+}
+
+// PositionEvent reports a new phone fix, for the "position" SSE event.
+type PositionEvent struct {
+	Position  Position             `json:"position"`
+	Residuals []NodeResidualReport `json:"residuals,omitempty"`
+}
+
+// eventsHandler streams position/node/heartbeat Server-Sent Events to
+// long-lived HTTP clients, so browsers and CLI tools get a push-based feed
+// without polling /visualization or opening a second WebSocket.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sub := subscribeEvents()
+	defer unsubscribeEvents(sub)
+
+	logger.Info("sse client connected", "remote_addr", r.RemoteAddr)
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub:
+			if !ok {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, event.data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, "event: heartbeat\ndata: {}\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			logger.Info("sse client disconnected", "remote_addr", r.RemoteAddr)
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics, so a deployment can be monitored behind Grafana
+// without wrapper scripts.
+var (
+	metricNodesConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "esp_nodes_connected",
+		Help: "Number of ESP8266 nodes with a live WebSocket connection.",
+	})
+
+	metricMessagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "esp_messages_total",
+		Help: "Total number of messages received from nodes, by node and message type.",
+	}, []string{"node", "type"})
+
+	metricRSSI = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "esp_rssi",
+		Help: "Most recent raw RSSI reading reported by a node.",
+	}, []string{"node"})
+
+	metricDistanceMeters = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "esp_distance_meters",
+		Help: "Most recent distance, in meters, calculated from a node's smoothed RSSI.",
+	}, []string{"node"})
+
+	metricTrilaterationResidual = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "esp_trilateration_residual_meters",
+		Help: "Root-mean-square per-node residual, in meters, from the last trilateration solve.",
+	})
+
+	metricTrilaterationIterations = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "esp_trilateration_iterations",
+		Help: "Number of solver iterations used by the last trilateration solve.",
+	})
+
+	metricTrilaterationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "esp_trilateration_duration_seconds",
+		Help:    "Wall-clock duration of each trilateration solve.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	metricPhonePosition = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "esp_phone_position",
+		Help: "Most recent solved phone position, by axis.",
+	}, []string{"axis"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		metricNodesConnected,
+		metricMessagesTotal,
+		metricRSSI,
+		metricDistanceMeters,
+		metricTrilaterationResidual,
+		metricTrilaterationIterations,
+		metricTrilaterationDuration,
+		metricPhonePosition,
+	)
+}
+
+// metricsHandler exposes the registered metrics for Prometheus scraping.
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTT ingestion mode: an optional second transport for deployments that
+// already publish sensor data over MQTT instead of (or in addition to) the
+// WebSocket endpoint. Disabled unless -mqtt-broker is set, so users who
+// don't use MQTT pay no cost.
+var (
+	mqttBroker      string
+	mqttTopicPrefix string
+	mqttUsername    string
+	mqttPassword    string
+	mqttClientID    string
+	mqttCACertPath  string
+
+	mqttClient mqtt.Client
+)
+
+func registerMQTTFlags() {
+	flag.StringVar(&mqttBroker, "mqtt-broker", "", "MQTT broker URL (e.g. tcp://localhost:1883); leave empty to disable MQTT ingestion")
+	flag.StringVar(&mqttTopicPrefix, "mqtt-topic-prefix", "esp", "Topic prefix for MQTT ingestion, e.g. \"esp\" subscribes to esp/+/distance")
+	flag.StringVar(&mqttUsername, "mqtt-username", "", "MQTT username")
+	flag.StringVar(&mqttPassword, "mqtt-password", "", "MQTT password")
+	flag.StringVar(&mqttClientID, "mqtt-client-id", "esp-trilateration-server", "MQTT client ID")
+	flag.StringVar(&mqttCACertPath, "mqtt-ca-cert", "", "Path to a PEM CA certificate for TLS MQTT brokers (e.g. ssl://host:8883)")
+}
+
+// startMQTT connects to the configured broker and subscribes to
+// <mqttTopicPrefix>/+/distance, feeding each message through the same
+// ingestDistanceReading pipeline as the WebSocket transport. It's a no-op
+// if -mqtt-broker wasn't set.
+func startMQTT() error {
+	if mqttBroker == "" {
+		return nil
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(mqttBroker).
+		SetClientID(mqttClientID).
+		SetAutoReconnect(true)
+
+	if mqttUsername != "" {
+		opts.SetUsername(mqttUsername)
+		opts.SetPassword(mqttPassword)
+	}
+
+	if mqttCACertPath != "" {
+		tlsConfig, err := mqttTLSConfig(mqttCACertPath)
+		if err != nil {
+			return fmt.Errorf("configuring MQTT TLS: %w", err)
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		logger.Info("connected to MQTT broker", "broker", mqttBroker)
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		logger.Warn("lost connection to MQTT broker", "broker", mqttBroker, "error", err)
+	})
+
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("connecting to MQTT broker %s: %w", mqttBroker, token.Error())
+	}
+
+	distanceTopic := fmt.Sprintf("%s/+/distance", mqttTopicPrefix)
+	if token := client.Subscribe(distanceTopic, 0, handleMQTTDistanceMessage); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("subscribing to %s: %w", distanceTopic, token.Error())
+	}
+
+	logger.Info("MQTT ingestion enabled", "broker", mqttBroker, "topic", distanceTopic)
+	mqttClient = client
+	return nil
+}
+
+// mqttTLSConfig loads a CA certificate so the client can verify a broker
+// presenting a certificate signed by a private CA.
+func mqttTLSConfig(caCertPath string) (*tls.Config, error) {
+	caCert, err := os.ReadFile(caCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA certificate: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificates found in %s", caCertPath)
+	}
+
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+// handleMQTTDistanceMessage parses an esp/<id>/distance message, using the
+// same Message JSON schema accepted over WebSocket, and feeds it through
+// the shared node-update pipeline. The node ID comes from the topic rather
+// than the payload, since that's the MQTT convention this subscribes to.
+func handleMQTTDistanceMessage(client mqtt.Client, mqttMsg mqtt.Message) {
+	nodeID, ok := nodeIDFromTopic(mqttMsg.Topic())
+	if !ok {
+		logger.Warn("MQTT message on unexpected topic", "topic", mqttMsg.Topic())
+		return
+	}
+
+	var msg Message
+	if err := json.Unmarshal(mqttMsg.Payload(), &msg); err != nil {
+		logger.Warn("invalid MQTT distance payload", "node_id", nodeID, "error", err)
+		return
+	}
+
+	getOrCreateNode(nodeID)
+	client.Publish(fmt.Sprintf("%s/%s/id", mqttTopicPrefix, nodeID), 0, false, "ID:"+nodeID)
+
+	ingestDistanceReading(nodeID, msg.RSSI, msg.Distance)
+}
+
+// nodeIDFromTopic extracts <id> from an "<prefix>/<id>/distance" topic.
+func nodeIDFromTopic(topic string) (string, bool) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 3 || parts[0] != mqttTopicPrefix || parts[2] != "distance" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// getOrCreateNode returns the node with the given ID, creating and
+// registering it (as MQTT has no WebSocket-style connect handshake) if it
+// hasn't been seen before.
+func getOrCreateNode(id string) *Node {
+	nodesMutex.Lock()
+	node, exists := nodes[id]
+	if !exists {
+		node = &Node{ID: id, Position: Position{X: 0, Y: 0, Z: 0}}
+		nodes[id] = node
+	}
+	nodesMutex.Unlock()
+
+	if !exists {
+		logger.Info("node first seen over MQTT", "node_id", id)
+		broadcastEvent("node", NodeEvent{NodeID: id, Status: "connected", Position: node.Position})
+		schedulePersist()
+	}
+	return node
+}
+
+// publishPhonePosition mirrors the solved phone position back to
+// esp/phone/position, so the MQTT flow is symmetric with the WebSocket ID
+// handshake. It's a no-op unless MQTT ingestion is enabled.
+func publishPhonePosition(position Position) {
+	if mqttClient == nil {
+		return
+	}
+	data, err := json.Marshal(position)
+	if err != nil {
+		logger.Error("error encoding phone position for MQTT", "error", err)
+		return
+	}
+	mqttClient.Publish(fmt.Sprintf("%s/phone/position", mqttTopicPrefix), 0, false, data)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// stateSchemaVersion is bumped whenever StateFile's shape changes in a way
+// that isn't backward compatible, so loadState can refuse an old or
+// newer-than-understood file instead of silently corrupting it.
+const stateSchemaVersion = 1
+
+// stateFilePath is where the node registry and calibration are snapshotted
+// so a server restart doesn't force re-calibrating every ESP8266.
+const stateFilePath = "state.json"
+
+// persistDebounce coalesces bursts of mutations (e.g. a stream of RSSI
+// updates) into a single disk write.
+const persistDebounce = 500 * time.Millisecond
+
+// StateFile is the on-disk (and /export, /import) snapshot format for the
+// node registry and calibration.
+type StateFile struct {
+	SchemaVersion int                          `json:"schemaVersion"`
+	Nodes         map[string]PersistedNode     `json:"nodes"`
+	Calibration   map[string]CalibrationParams `json:"calibration"`
+	Smoothing     map[string]SmoothingConfig   `json:"smoothing"`
+}
+
+// PersistedNode is the subset of Node that survives a restart: its fixed
+// position and smoothing filter state. The live connection and transient
+// fields like RSSI/Distance/LastSeen are rebuilt from the next message.
+type PersistedNode struct {
+	Position             Position    `json:"position"`
+	Kalman               KalmanState `json:"kalman"`
+	SmoothedRSSI         float64     `json:"smoothedRssi"`
+	SmoothingInitialized bool        `json:"smoothingInitialized"`
+}
+
+var (
+	persistMutex sync.Mutex
+	persistTimer *time.Timer
+)
+
+// schedulePersist debounces a snapshot of the current state to disk. Call
+// it after any mutation to nodes, calibration, or smoothingConfig.
+func schedulePersist() {
+	persistMutex.Lock()
+	defer persistMutex.Unlock()
+	if persistTimer != nil {
+		persistTimer.Stop()
+	}
+	persistTimer = time.AfterFunc(persistDebounce, func() {
+		if err := saveState(stateFilePath); err != nil {
+			logger.Error("error persisting state", "error", err)
+		}
+	})
+}
+
+// snapshotState builds a StateFile from the live nodes/calibration maps.
+// Callers must hold nodesMutex for reading.
+func snapshotState() StateFile {
+	persistedNodes := make(map[string]PersistedNode, len(nodes))
+	for id, node := range nodes {
+		persistedNodes[id] = PersistedNode{
+			Position:             node.Position,
+			Kalman:               node.Kalman,
+			SmoothedRSSI:         node.SmoothedRSSI,
+			SmoothingInitialized: node.smoothingInitialized,
+		}
+	}
+
+	calibrationCopy := make(map[string]CalibrationParams, len(calibration))
+	for id, params := range calibration {
+		calibrationCopy[id] = params
+	}
+
+	smoothingCopy := make(map[string]SmoothingConfig, len(smoothingConfig))
+	for id, cfg := range smoothingConfig {
+		smoothingCopy[id] = cfg
+	}
+
+	return StateFile{
+		SchemaVersion: stateSchemaVersion,
+		Nodes:         persistedNodes,
+		Calibration:   calibrationCopy,
+		Smoothing:     smoothingCopy,
+	}
+}
+
+// saveState writes the current state to path, via a temp file and rename
+// so a crash mid-write can't corrupt the existing snapshot.
+func saveState(path string) error {
+	nodesMutex.RLock()
+	state := snapshotState()
+	nodesMutex.RUnlock()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("replacing state file: %w", err)
+	}
+	return nil
+}
+
+// applyState replaces the live nodes/calibration/smoothingConfig maps with
+// the contents of state. Callers must hold nodesMutex for writing.
+func applyState(state StateFile) {
+	nodes = make(map[string]*Node, len(state.Nodes))
+	for id, persisted := range state.Nodes {
+		nodes[id] = &Node{
+			ID:                   id,
+			Position:             persisted.Position,
+			Kalman:               persisted.Kalman,
+			SmoothedRSSI:         persisted.SmoothedRSSI,
+			smoothingInitialized: persisted.SmoothingInitialized,
+		}
+	}
+
+	if state.Calibration != nil {
+		calibration = state.Calibration
+	}
+	if state.Smoothing != nil {
+		smoothingConfig = state.Smoothing
+	}
+}
+
+// loadState reads path, if present, and restores the node registry and
+// calibration from it. It's called once at startup before the HTTP server
+// binds. A missing file is not an error; an unreadable schema version is,
+// so a future incompatible format doesn't silently corrupt the server.
+func loadState(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading state file: %w", err)
+	}
+
+	var state StateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("decoding state file: %w", err)
+	}
+	if state.SchemaVersion != stateSchemaVersion {
+		return fmt.Errorf("state file schema version %d is not supported (expected %d)",
+			state.SchemaVersion, stateSchemaVersion)
+	}
+
+	nodesMutex.Lock()
+	applyState(state)
+	nodesMutex.Unlock()
+
+	logger.Info("loaded persisted state", "node_count", len(state.Nodes), "path", path)
+	return nil
+}
+
+// nodesHandler lists all known nodes (GET) or removes one from the
+// registry by ID (DELETE).
+func nodesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		nodesMutex.RLock()
+		list := make([]*Node, 0, len(nodes))
+		for _, node := range nodes {
+			list = append(list, node)
+		}
+		nodesMutex.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			logger.Error("error encoding node list", "error", err)
+			http.Error(w, "Error encoding data", http.StatusInternalServerError)
+		}
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "Missing id parameter", http.StatusBadRequest)
+			return
+		}
+
+		nodesMutex.Lock()
+		_, exists := nodes[id]
+		delete(nodes, id)
+		nodesMutex.Unlock()
+
+		if !exists {
+			http.Error(w, "Node not found", http.StatusNotFound)
+			return
+		}
+
+		logger.Info("deleted node from registry", "node_id", id)
+		schedulePersist()
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, `{"status":"ok"}`)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// exportHandler dumps the full site configuration (nodes, calibration,
+// smoothing) as a downloadable JSON snapshot, in the same format loadState
+// reads at startup.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	nodesMutex.RLock()
+	state := snapshotState()
+	nodesMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="esp-site-config.json"`)
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		logger.Error("error encoding export", "error", err)
+		http.Error(w, "Error encoding data", http.StatusInternalServerError)
+	}
+}
+
+// importHandler restores a full site configuration previously produced by
+// /export, replacing the live node registry and calibration.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var state StateFile
+	if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if state.SchemaVersion != stateSchemaVersion {
+		http.Error(w, fmt.Sprintf("unsupported schema version %d (expected %d)",
+			state.SchemaVersion, stateSchemaVersion), http.StatusBadRequest)
+		return
+	}
+
+	nodesMutex.Lock()
+	applyState(state)
+	nodesMutex.Unlock()
+
+	logger.Info("imported nodes from uploaded configuration", "node_count", len(state.Nodes))
+	schedulePersist()
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"status":"ok"}`)
+}
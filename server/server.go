@@ -2,8 +2,9 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
 	"os"
@@ -12,10 +13,9 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
-)
 
-// Configuration
-const serverPort = 3200
+	"github.com/Jefferson-Butler1/esp/internal/locate"
+)
 
 // Position in 3D space
 type Position struct {
@@ -26,12 +26,39 @@ type Position struct {
 
 // Node represents an ESP8266 device
 type Node struct {
-	ID       string   `json:"id"`
-	Conn     *websocket.Conn `json:"-"` // Don't include in JSON
-	Position Position `json:"position"` // Fixed position of the node
-	Distance float64  `json:"distance"` // Measured distance to the target
-	RSSI     int      `json:"rssi"`     // Raw RSSI value
-	LastSeen time.Time `json:"lastSeen"`
+	ID       string          `json:"id"`
+	Conn     *websocket.Conn `json:"-"`        // Don't include in JSON
+	Position Position        `json:"position"` // Fixed position of the node
+	Distance float64         `json:"distance"` // Measured distance to the target
+	RSSI     int             `json:"rssi"`     // Raw RSSI value
+	LastSeen time.Time       `json:"lastSeen"`
+
+	Kalman               KalmanState `json:"kalman"`       // Smoothing filter state
+	SmoothedRSSI         float64     `json:"smoothedRssi"` // Output of the configured smoother
+	smoothingInitialized bool        // whether Kalman/SmoothedRSSI has seen a first sample
+}
+
+// KalmanState is the state of a per-node 1D Kalman filter used to smooth
+// raw RSSI readings before they're converted to a distance.
+type KalmanState struct {
+	X float64 `json:"x"` // smoothed RSSI estimate
+	P float64 `json:"p"` // estimate covariance
+}
+
+// SmoothingMode selects how a node's raw RSSI samples are smoothed.
+type SmoothingMode string
+
+const (
+	SmoothingKalman SmoothingMode = "kalman"
+	SmoothingEWMA   SmoothingMode = "ewma"
+)
+
+// SmoothingConfig holds the tunable parameters of a node's RSSI smoother.
+type SmoothingConfig struct {
+	Mode  SmoothingMode `json:"mode"`
+	Q     float64       `json:"q"`     // process noise (Kalman)
+	R     float64       `json:"r"`     // measurement noise (Kalman)
+	Alpha float64       `json:"alpha"` // weight given to each new sample (EWMA)
 }
 
 // Message types from ESP nodes
@@ -44,8 +71,19 @@ type Message struct {
 
 // Visualization data structure
 type VisualizationData struct {
-	Nodes   map[string]Position `json:"nodes"`
-	Clients map[string]Position `json:"clients"`
+	Nodes     map[string]Position  `json:"nodes"`
+	Clients   map[string]Position  `json:"clients"`
+	Residuals []NodeResidualReport `json:"residuals,omitempty"`
+}
+
+// NodeResidualReport is the JSON shape of a single node's contribution to
+// the last trilateration solve, so the visualization UI can show which
+// nodes were trusted.
+type NodeResidualReport struct {
+	NodeID   string  `json:"nodeId"`
+	Residual float64 `json:"residual"`
+	Weight   float64 `json:"weight"`
+	Inlier   bool    `json:"inlier"`
 }
 
 // Calibration parameters
@@ -62,38 +100,51 @@ var (
 		CheckOrigin:     func(r *http.Request) bool { return true }, // Allow all origins
 	}
 
-	nodes        = make(map[string]*Node)
-	nodesMutex   sync.RWMutex
-	logger       = log.New(os.Stdout, "", log.LstdFlags)
-	
+	nodes      = make(map[string]*Node)
+	nodesMutex sync.RWMutex
+	logger     = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	// logFormat selects the /metrics-adjacent structured log encoding;
+	// set via the -log-format flag before logger is reconfigured in main.
+	logFormat string
+
 	// Phone position (target device)
 	phonePosition = Position{X: 0, Y: 0, Z: 0}
-	
-	// Default calibration parameters (can be updated via API)
+
+	// Diagnostics from the most recent trilateration solve, surfaced in
+	// the visualization JSON so users can see which nodes were trusted.
+	lastDiagnostics locate.Diagnostics
+
+	// Default calibration parameters (can be updated via API). Seeded from
+	// Config.DefaultCalibration in main before any node connects.
 	calibration = map[string]CalibrationParams{
-		"default": {RSSIAt1m: -60.0, PathLoss: 2.0},
+		"default": defaultConfig().DefaultCalibration,
+	}
+
+	// Default RSSI smoothing parameters (can be updated via /kalman-config)
+	smoothingConfig = map[string]SmoothingConfig{
+		"default": {Mode: SmoothingKalman, Q: 0.1, R: 4.0, Alpha: 0.3},
 	}
 )
 
 // WebSocket handler function
 func wsHandler(w http.ResponseWriter, r *http.Request) {
-	logger.Printf("New connection request from %s", r.RemoteAddr)
-	
+	logger.Info("new connection request", "remote_addr", r.RemoteAddr)
+
 	// Log all request headers for debugging
-	logger.Println("Request headers:")
 	for name, values := range r.Header {
 		for _, value := range values {
-			logger.Printf("  %s: %s", name, value)
+			logger.Debug("request header", "name", name, "value", value)
 		}
 	}
-	
+
 	// More permissive upgrader for debugging
 	upgrader.CheckOrigin = func(r *http.Request) bool { return true }
 	upgrader.EnableCompression = false
-	
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		logger.Printf("Error upgrading connection: %v", err)
+		logger.Error("error upgrading connection", "error", err)
 		return
 	}
 
@@ -104,25 +155,32 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 	cleanIP := strings.ReplaceAll(strings.ReplaceAll(ipAddress, ".", "_"), ":", "_")
 	tempID := fmt.Sprintf("ESP_%s", cleanIP)
 
-	// Create new node
-	newNode := &Node{
-		ID:       tempID,
-		Conn:     conn,
-		Position: Position{X: 0, Y: 0, Z: 0}, // Will be configured later
-		Distance: 0,
-		LastSeen: time.Now(),
-	}
-
-	// Store node
+	// Reuse the existing node (e.g. restored from persisted state, or
+	// reconnecting) if there is one, so its Position/Kalman/SmoothedRSSI
+	// survive; only create a fresh zeroed Node the first time this ID is
+	// ever seen.
 	nodesMutex.Lock()
-	nodes[tempID] = newNode
+	newNode, exists := nodes[tempID]
+	if !exists {
+		newNode = &Node{
+			ID:       tempID,
+			Position: Position{X: 0, Y: 0, Z: 0}, // Will be configured later
+		}
+		nodes[tempID] = newNode
+	}
+	newNode.Conn = conn
+	newNode.Distance = 0
+	newNode.LastSeen = time.Now()
 	nodesMutex.Unlock()
 
-	logger.Printf("Client connected: %s", tempID)
+	logger.Info("client connected", "node_id", tempID)
+	broadcastEvent("node", NodeEvent{NodeID: tempID, Status: "connected", Position: newNode.Position})
+	schedulePersist()
+	metricNodesConnected.Inc()
 
 	// Set ping handler
 	conn.SetPingHandler(func(appData string) error {
-		logger.Printf("Received ping from %s", tempID)
+		logger.Debug("received ping", "node_id", tempID)
 		return conn.WriteControl(websocket.PongMessage, []byte{}, time.Now().Add(10*time.Second))
 	})
 
@@ -135,48 +193,59 @@ func handleMessages(conn *websocket.Conn, nodeID string) {
 	nodesMutex.Lock()
 	existingNode, exists := nodes[nodeID]
 	if exists {
-		// If the node exists but has a different connection, close the old one
+		// If the node exists but has a different connection, close the old one.
+		// existingNode.Conn can be nil here: the node may already be
+		// disconnected, or its entry may have come from persisted/imported
+		// state, which never has a live connection.
 		if existingNode.Conn != conn {
-			logger.Printf("Node %s reconnected with new connection", nodeID)
-			existingNode.Conn.Close()
+			logger.Info("node reconnected with new connection", "node_id", nodeID)
+			if existingNode.Conn != nil {
+				existingNode.Conn.Close()
+			}
 			existingNode.Conn = conn
 		}
 	}
 	nodesMutex.Unlock()
 
 	defer func() {
-		logger.Printf("Closing connection for %s", nodeID)
+		logger.Info("closing connection", "node_id", nodeID)
 		conn.Close()
 
 		// We no longer automatically remove the node from the map
 		// This allows the node to reconnect while maintaining its position and calibration
 		// Instead, we just mark it as disconnected by setting Conn to nil
+		var disconnected bool
 		nodesMutex.Lock()
 		if node, nodeExists := nodes[nodeID]; nodeExists && node.Conn == conn {
 			// Only set to nil if this is still the active connection
 			node.Conn = nil
-			logger.Printf("Node %s marked as disconnected", nodeID)
+			logger.Info("node marked as disconnected", "node_id", nodeID)
+			disconnected = true
 		}
 		nodesMutex.Unlock()
+		if disconnected {
+			broadcastEvent("node", NodeEvent{NodeID: nodeID, Status: "disconnected"})
+			metricNodesConnected.Dec()
+		}
 	}()
 
 	// Wait a moment before sending first message
 	time.Sleep(500 * time.Millisecond)
-	
+
 	// Send ID immediately after connection
-	logger.Printf("Sending ID to %s", nodeID)
+	logger.Debug("sending id", "node_id", nodeID)
 	err := conn.WriteMessage(websocket.TextMessage, []byte("ID:"+nodeID))
 	if err != nil {
-		logger.Printf("Error sending ID to node %s: %v", nodeID, err)
+		logger.Error("error sending id to node", "node_id", nodeID, "error", err)
 		return
 	}
-	
-	logger.Printf("ID sent to %s", nodeID)
+
+	logger.Debug("id sent", "node_id", nodeID)
 
 	for {
 		messageType, message, err := conn.ReadMessage()
 		if err != nil {
-			logger.Printf("Read error from %s: %v", nodeID, err)
+			logger.Info("read error", "node_id", nodeID, "error", err)
 			break
 		}
 
@@ -190,27 +259,19 @@ func handleMessages(conn *websocket.Conn, nodeID string) {
 		// Try to parse as JSON
 		var msg Message
 		jsonErr := json.Unmarshal(message, &msg)
-		
+
 		if jsonErr == nil {
 			// Handle structured message
+			metricMessagesTotal.WithLabelValues(nodeID, msg.Type).Inc()
+
 			switch msg.Type {
 			case "distance":
-				logger.Printf("Received distance data from %s: RSSI=%d, Distance=%.2fm", nodeID, msg.RSSI, msg.Distance)
-				
-				nodesMutex.Lock()
-				if node, exists := nodes[nodeID]; exists {
-					node.RSSI = msg.RSSI
-					node.Distance = msg.Distance
-				}
-				nodesMutex.Unlock()
-				
-				// Recalculate phone position
-				updatePhonePosition()
-				
+				ingestDistanceReading(nodeID, msg.RSSI, msg.Distance)
+
 			case "calibration":
-				logger.Printf("Received calibration data from %s", nodeID)
+				logger.Info("received calibration data", "node_id", nodeID)
 				// Handle calibration data
-				
+
 			case "position":
 				// Handle node reporting its position
 				var pos Position
@@ -218,28 +279,31 @@ func handleMessages(conn *websocket.Conn, nodeID string) {
 					nodesMutex.Lock()
 					if node, exists := nodes[nodeID]; exists {
 						node.Position = pos
-						logger.Printf("Updated position for %s: (%.2f, %.2f, %.2f)", 
-							nodeID, pos.X, pos.Y, pos.Z)
+						logger.Info("updated node position", "node_id", nodeID, "x", pos.X, "y", pos.Y, "z", pos.Z)
 					}
 					nodesMutex.Unlock()
+					broadcastEvent("node", NodeEvent{NodeID: nodeID, Status: "position-changed", Position: pos})
+					schedulePersist()
 				}
 			}
 		} else {
 			// Handle as plain text message
 			messageStr := string(message)
-			logger.Printf("Received text message from %s: %s", nodeID, messageStr)
+			logger.Debug("received text message", "node_id", nodeID, "message", messageStr)
 
 			// Echo the message back
 			if messageStr == "PING" {
-				logger.Printf("Sending PONG to %s", nodeID)
+				metricMessagesTotal.WithLabelValues(nodeID, "ping").Inc()
+				logger.Debug("sending pong", "node_id", nodeID)
 				if err := conn.WriteMessage(messageType, []byte("PONG")); err != nil {
-					logger.Printf("Error sending PONG to %s: %v", nodeID, err)
+					logger.Error("error sending pong", "node_id", nodeID, "error", err)
 					break
 				}
 			} else if messageStr == "REGISTER" {
-				logger.Printf("Got REGISTER from %s, sending ID confirmation", nodeID)
+				metricMessagesTotal.WithLabelValues(nodeID, "register").Inc()
+				logger.Info("got register, sending id confirmation", "node_id", nodeID)
 				if err := conn.WriteMessage(websocket.TextMessage, []byte("ID:"+nodeID)); err != nil {
-					logger.Printf("Error sending ID confirmation to %s: %v", nodeID, err)
+					logger.Error("error sending id confirmation", "node_id", nodeID, "error", err)
 					break
 				}
 			}
@@ -248,20 +312,81 @@ func handleMessages(conn *websocket.Conn, nodeID string) {
 }
 
 // Calculate distance from RSSI
-func calculateDistanceFromRSSI(rssi int, nodeID string) float64 {
+func calculateDistanceFromRSSI(rssi float64, nodeID string) float64 {
 	params, ok := calibration[nodeID]
 	if !ok {
 		params = calibration["default"]
 	}
-	
-	return math.Pow(10, (params.RSSIAt1m - float64(rssi)) / (10 * params.PathLoss))
+
+	return math.Pow(10, (params.RSSIAt1m-rssi)/(10*params.PathLoss))
+}
+
+// smoothRSSI runs the configured smoother (Kalman or EWMA) for node over a
+// new raw RSSI reading and returns the smoothed value. Callers must hold
+// nodesMutex for writing, since it mutates node's filter state.
+func smoothRSSI(node *Node, rawRSSI float64) float64 {
+	cfg, ok := smoothingConfig[node.ID]
+	if !ok {
+		cfg = smoothingConfig["default"]
+	}
+
+	switch cfg.Mode {
+	case SmoothingEWMA:
+		if !node.smoothingInitialized {
+			node.SmoothedRSSI = rawRSSI
+		} else {
+			node.SmoothedRSSI = cfg.Alpha*rawRSSI + (1-cfg.Alpha)*node.SmoothedRSSI
+		}
+	default: // SmoothingKalman
+		if !node.smoothingInitialized {
+			node.Kalman.X = rawRSSI
+			node.Kalman.P = cfg.R
+		}
+		node.Kalman.P += cfg.Q
+		k := node.Kalman.P / (node.Kalman.P + cfg.R)
+		node.Kalman.X += k * (rawRSSI - node.Kalman.X)
+		node.Kalman.P *= 1 - k
+		node.SmoothedRSSI = node.Kalman.X
+	}
+	node.smoothingInitialized = true
+
+	return node.SmoothedRSSI
+}
+
+// ingestDistanceReading applies a raw RSSI reading from nodeID, however it
+// arrived (WebSocket or MQTT), through the shared smoothing, calibration,
+// persistence and trilateration pipeline. rawDistance is accepted for
+// logging parity with the sender but isn't trusted directly: the server
+// always recomputes distance from the smoothed RSSI and this node's
+// calibration.
+func ingestDistanceReading(nodeID string, rssi int, rawDistance float64) {
+	logger.Info("received distance data", "node_id", nodeID, "rssi", rssi, "reported_distance", rawDistance)
+
+	nodesMutex.Lock()
+	node, exists := nodes[nodeID]
+	if exists {
+		node.RSSI = rssi
+		smoothed := smoothRSSI(node, float64(rssi))
+		node.Distance = calculateDistanceFromRSSI(smoothed, nodeID)
+		metricRSSI.WithLabelValues(nodeID).Set(float64(rssi))
+		metricDistanceMeters.WithLabelValues(nodeID).Set(node.Distance)
+	}
+	nodesMutex.Unlock()
+	if !exists {
+		logger.Warn("distance reading for unknown node", "node_id", nodeID)
+		return
+	}
+	schedulePersist()
+
+	// Recalculate phone position
+	updatePhonePosition()
 }
 
 // Trilateration algorithm to determine phone position
 func updatePhonePosition() {
 	nodesMutex.RLock()
 	defer nodesMutex.RUnlock()
-	
+
 	// Need at least 3 nodes with distances for 3D trilateration
 	var validNodes []*Node
 	for _, node := range nodes {
@@ -269,76 +394,92 @@ func updatePhonePosition() {
 			validNodes = append(validNodes, node)
 		}
 	}
-	
+
 	if len(validNodes) < 3 {
-		logger.Printf("Not enough nodes with distance measurements for trilateration: %d", len(validNodes))
+		logger.Info("not enough nodes with distance measurements for trilateration", "node_count", len(validNodes))
 		return
 	}
-	
-	// Use non-linear least squares algorithm for trilateration
+
 	// Starting with a guess at the center of the system
 	initialGuess := Position{X: 0, Y: 0, Z: 0}
-	
-	// Simple implementation using gradient descent
-	// In a production system, you'd use a more robust solver
-	position := trilateratePosition(validNodes, initialGuess)
-	
-	logger.Printf("Updated phone position: (%.2f, %.2f, %.2f)", position.X, position.Y, position.Z)
+
+	start := time.Now()
+	position, diagnostics := trilateratePosition(validNodes, initialGuess)
+	metricTrilaterationDuration.Observe(time.Since(start).Seconds())
+	metricTrilaterationIterations.Set(float64(diagnostics.Iterations))
+	metricTrilaterationResidual.Set(rmsResidual(diagnostics))
+	metricPhonePosition.WithLabelValues("x").Set(position.X)
+	metricPhonePosition.WithLabelValues("y").Set(position.Y)
+	metricPhonePosition.WithLabelValues("z").Set(position.Z)
+
+	logger.Info("updated phone position",
+		"x", position.X, "y", position.Y, "z", position.Z,
+		"iterations", diagnostics.Iterations, "converged", diagnostics.Converged, "error", diagnostics.FinalError)
 	phonePosition = position
+	lastDiagnostics = diagnostics
+
+	broadcastEvent("position", PositionEvent{Position: position, Residuals: residualReports(diagnostics)})
+	publishPhonePosition(position)
 }
 
-// Trilateration using gradient descent
-func trilateratePosition(nodes []*Node, initialGuess Position) Position {
-	// Implementation parameters
-	maxIterations := 100
-	learningRate := 0.1
-	convergenceThreshold := 0.001
-	
-	position := initialGuess
-	
-	for iteration := 0; iteration < maxIterations; iteration++ {
-		// Calculate gradient
-		gradient := Position{X: 0, Y: 0, Z: 0}
-		totalError := 0.0
-		
-		for _, node := range nodes {
-			// Calculate actual distance from current estimated position to the node
-			dx := position.X - node.Position.X
-			dy := position.Y - node.Position.Y
-			dz := position.Z - node.Position.Z
-			calculatedDistance := math.Sqrt(dx*dx + dy*dy + dz*dz)
-			
-			// Error is the difference between calculated and measured distance
-			error := calculatedDistance - node.Distance
-			totalError += error * error
-			
-			// Calculate gradient components
-			if calculatedDistance > 0 {
-				gradient.X += 2 * error * dx / calculatedDistance
-				gradient.Y += 2 * error * dy / calculatedDistance
-				gradient.Z += 2 * error * dz / calculatedDistance
-			}
-		}
-		
-		// Update position using gradient descent
-		position.X -= learningRate * gradient.X
-		position.Y -= learningRate * gradient.Y
-		position.Z -= learningRate * gradient.Z
-		
-		// Check for convergence
-		gradientMagnitude := math.Sqrt(gradient.X*gradient.X + gradient.Y*gradient.Y + gradient.Z*gradient.Z)
-		if gradientMagnitude < convergenceThreshold {
-			logger.Printf("Trilateration converged after %d iterations, error: %.6f", iteration, totalError)
-			break
-		}
-		
-		// If we reach the last iteration without converging
-		if iteration == maxIterations-1 {
-			logger.Printf("Trilateration did not fully converge after %d iterations, error: %.6f", maxIterations, totalError)
+// rmsResidual summarizes a solve's per-node residuals as a single root-
+// mean-square value, in meters, for the esp_trilateration_residual_meters
+// gauge.
+func rmsResidual(diagnostics locate.Diagnostics) float64 {
+	if len(diagnostics.Residuals) == 0 {
+		return 0
+	}
+	var sumSquares float64
+	for _, r := range diagnostics.Residuals {
+		sumSquares += r.Residual * r.Residual
+	}
+	return math.Sqrt(sumSquares / float64(len(diagnostics.Residuals)))
+}
+
+// residualReports converts solver diagnostics into the JSON shape used by
+// the visualization and events endpoints.
+func residualReports(diagnostics locate.Diagnostics) []NodeResidualReport {
+	var reports []NodeResidualReport
+	for _, r := range diagnostics.Residuals {
+		reports = append(reports, NodeResidualReport{
+			NodeID:   r.NodeID,
+			Residual: r.Residual,
+			Weight:   r.Weight,
+			Inlier:   r.Inlier,
+		})
+	}
+	return reports
+}
+
+// trilateratePosition estimates the phone position from node distance
+// measurements using a Levenberg-Marquardt solver wrapped in a RANSAC outer
+// loop, so a single bad RSSI-derived distance doesn't throw off the fit.
+// See internal/locate for the solver itself.
+func trilateratePosition(nodes []*Node, initialGuess Position) (Position, locate.Diagnostics) {
+	locateNodes := make([]locate.Node, len(nodes))
+	for i, n := range nodes {
+		locateNodes[i] = locate.Node{
+			ID:       n.ID,
+			Position: locate.Position(n.Position),
+			Distance: n.Distance,
 		}
 	}
-	
-	return position
+
+	position, diagnostics := locate.Solve(locateNodes, locate.Position(initialGuess), trilaterationOptions())
+	return Position(position), diagnostics
+}
+
+// trilaterationOptions builds locate.Options from the live config's
+// trilateration hyperparameters. Fields Config doesn't expose (RANSAC
+// iterations, inlier threshold, IRLS passes, weighting) are left zero, so
+// locate.Solve's internal defaulting fills them in.
+func trilaterationOptions() locate.Options {
+	cfg := currentConfig()
+	return locate.Options{
+		MaxIterations:        cfg.Trilateration.MaxIterations,
+		InitialLambda:        cfg.Trilateration.LearningRate,
+		ConvergenceThreshold: cfg.Trilateration.ConvergenceThreshold,
+	}
 }
 
 // Handler for calibration
@@ -347,30 +488,123 @@ func calibrationHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	
-	// Parse input
+
+	// Parse input. Q/R are optional and, when present, update this node's
+	// smoothing config alongside its path-loss calibration.
 	var input struct {
-		NodeID     string  `json:"node_id"`
-		RSSIAt1m   float64 `json:"rssi_at_1m"`
-		PathLoss   float64 `json:"path_loss"`
+		NodeID   string   `json:"node_id"`
+		RSSIAt1m float64  `json:"rssi_at_1m"`
+		PathLoss float64  `json:"path_loss"`
+		Q        *float64 `json:"q,omitempty"`
+		R        *float64 `json:"r,omitempty"`
 	}
-	
+
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
-	
+
 	// Update calibration for this node
 	nodesMutex.Lock()
 	calibration[input.NodeID] = CalibrationParams{
 		RSSIAt1m: input.RSSIAt1m,
 		PathLoss: input.PathLoss,
 	}
+	if input.Q != nil || input.R != nil {
+		cfg, ok := smoothingConfig[input.NodeID]
+		if !ok {
+			cfg = smoothingConfig["default"]
+		}
+		if input.Q != nil {
+			cfg.Q = *input.Q
+		}
+		if input.R != nil {
+			cfg.R = *input.R
+		}
+		smoothingConfig[input.NodeID] = cfg
+	}
 	nodesMutex.Unlock()
-	
-	logger.Printf("Updated calibration for node %s: RSSI@1m=%.2f, PathLoss=%.2f", 
-		input.NodeID, input.RSSIAt1m, input.PathLoss)
-	
+	schedulePersist()
+
+	logger.Info("updated calibration for node", "node_id", input.NodeID, "rssi_at_1m", input.RSSIAt1m, "path_loss", input.PathLoss)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"status":"ok"}`)
+}
+
+// Handler for per-node RSSI smoothing configuration.
+func kalmanConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input struct {
+		NodeID string        `json:"node_id"`
+		Mode   SmoothingMode `json:"mode"`
+		Q      float64       `json:"q"`
+		R      float64       `json:"r"`
+		Alpha  float64       `json:"alpha"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if input.Mode != SmoothingKalman && input.Mode != SmoothingEWMA {
+		http.Error(w, "mode must be \"kalman\" or \"ewma\"", http.StatusBadRequest)
+		return
+	}
+
+	nodesMutex.Lock()
+	smoothingConfig[input.NodeID] = SmoothingConfig{
+		Mode:  input.Mode,
+		Q:     input.Q,
+		R:     input.R,
+		Alpha: input.Alpha,
+	}
+	nodesMutex.Unlock()
+	schedulePersist()
+
+	logger.Info("updated smoothing config for node",
+		"node_id", input.NodeID, "mode", input.Mode, "q", input.Q, "r", input.R, "alpha", input.Alpha)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"status":"ok"}`)
+}
+
+// Handler to reset a node's smoothing filter back to an uninitialized
+// state, so its next reading seeds the filter instead of being blended
+// with stale history.
+func kalmanResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input struct {
+		NodeID string `json:"node_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	nodesMutex.Lock()
+	node, exists := nodes[input.NodeID]
+	if !exists {
+		nodesMutex.Unlock()
+		http.Error(w, "Node not found", http.StatusNotFound)
+		return
+	}
+	node.Kalman = KalmanState{}
+	node.SmoothedRSSI = 0
+	node.smoothingInitialized = false
+	nodesMutex.Unlock()
+	schedulePersist()
+
+	logger.Info("reset smoothing filter for node", "node_id", input.NodeID)
+
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, `{"status":"ok"}`)
 }
@@ -379,28 +613,32 @@ func calibrationHandler(w http.ResponseWriter, r *http.Request) {
 func visualizationHandler(w http.ResponseWriter, r *http.Request) {
 	nodesMutex.RLock()
 	defer nodesMutex.RUnlock()
-	
+
 	// Prepare node positions
 	nodePositions := make(map[string]Position)
 	for id, node := range nodes {
 		nodePositions[id] = node.Position
 	}
-	
+
+	// Prepare per-node residuals from the last trilateration solve
+	residuals := residualReports(lastDiagnostics)
+
 	// Prepare visualization data
 	data := VisualizationData{
 		Nodes: nodePositions,
 		Clients: map[string]Position{
 			"PHONE": phonePosition,
 		},
+		Residuals: residuals,
 	}
-	
+
 	// Set response headers
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow cross-origin requests
-	
+
 	// Send JSON response
 	if err := json.NewEncoder(w).Encode(data); err != nil {
-		logger.Printf("Error encoding visualization data: %v", err)
+		logger.Error("error encoding visualization data", "error", err)
 		http.Error(w, "Error encoding data", http.StatusInternalServerError)
 		return
 	}
@@ -412,18 +650,18 @@ func setNodePositionHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	
+
 	// Parse input
 	var input struct {
-		NodeID string   `json:"node_id"`
+		NodeID   string   `json:"node_id"`
 		Position Position `json:"position"`
 	}
-	
+
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
-	
+
 	// Update node position
 	nodesMutex.Lock()
 	node, exists := nodes[input.NodeID]
@@ -432,42 +670,61 @@ func setNodePositionHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Node not found", http.StatusNotFound)
 		return
 	}
-	
+
 	node.Position = input.Position
 	nodesMutex.Unlock()
-	
-	logger.Printf("Set position for node %s: (%.2f, %.2f, %.2f)", 
-		input.NodeID, input.Position.X, input.Position.Y, input.Position.Z)
-	
+	schedulePersist()
+
+	broadcastEvent("node", NodeEvent{NodeID: input.NodeID, Status: "position-changed", Position: input.Position})
+
+	logger.Info("set position for node", "node_id", input.NodeID,
+		"x", input.Position.X, "y", input.Position.Y, "z", input.Position.Z)
+
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, `{"status":"ok"}`)
 }
 
+// countConnectedNodes returns how many nodes currently have a live
+// connection, for seeding the esp_nodes_connected gauge at startup.
+func countConnectedNodes() int {
+	nodesMutex.RLock()
+	defer nodesMutex.RUnlock()
+
+	count := 0
+	for _, node := range nodes {
+		if node.Conn != nil {
+			count++
+		}
+	}
+	return count
+}
+
 // Print status of all nodes
 func printNodesStatus() {
 	nodesMutex.RLock()
 	defer nodesMutex.RUnlock()
-	
-	logger.Println("\n=== Node Status ===")
-	logger.Printf("Total nodes: %d", len(nodes))
-	
+
+	staleAfter := currentConfig().StaleNodeTimeout
+	logger.Info("node status", "total_nodes", len(nodes))
+
 	for id, node := range nodes {
+		timeSinceLastSeen := time.Since(node.LastSeen)
 		status := "DISCONNECTED"
 		if node.Conn != nil {
 			status = "CONNECTED"
+			if timeSinceLastSeen > staleAfter {
+				status = "STALE"
+			}
 		}
-		
-		timeSinceLastSeen := time.Since(node.LastSeen)
-		logger.Printf("Node %s: %s, Position: (%.2f, %.2f, %.2f), Last seen: %s ago", 
-			id, status, node.Position.X, node.Position.Y, node.Position.Z, 
-			timeSinceLastSeen.Round(time.Second))
+
+		logger.Info("node status detail", "node_id", id, "status", status,
+			"x", node.Position.X, "y", node.Position.Y, "z", node.Position.Z,
+			"last_seen_ago", timeSinceLastSeen.Round(time.Second).String())
 	}
-	
+
 	if len(nodes) > 0 {
-		logger.Printf("Phone position: (%.2f, %.2f, %.2f)", 
-			phonePosition.X, phonePosition.Y, phonePosition.Z)
+		logger.Info("phone position", "x", phonePosition.X, "y", phonePosition.Y, "z", phonePosition.Z)
 	}
-	logger.Println("==================")
 }
 
 // Simple HTML page for testing
@@ -592,10 +849,57 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 
 // Main function
 func main() {
+	flag.StringVar(&logFormat, "log-format", "text", "log output format: json or text")
+	registerMQTTFlags()
+	registerConfigFlags()
+	flag.Parse()
+
+	if printExampleConfigFlag {
+		writeExampleConfig()
+		return
+	}
+
+	switch logFormat {
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	case "text":
+		logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -log-format %q: must be \"json\" or \"text\"\n", logFormat)
+		os.Exit(1)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Error("error loading config", "error", err)
+		os.Exit(1)
+	}
+	setConfig(cfg)
+
+	// Restore nodes and calibration from the last run before accepting
+	// any connections.
+	if err := loadState(stateFilePath); err != nil {
+		logger.Error("error loading persisted state", "error", err)
+	}
+	// Apply the config's default calibration on top of whatever was
+	// restored, so changing -default-calibration (flag/env/config file)
+	// always takes effect on restart instead of losing to a stale
+	// persisted value.
+	calibration["default"] = cfg.DefaultCalibration
+	metricNodesConnected.Set(float64(countConnectedNodes()))
+
+	if err := startMQTT(); err != nil {
+		logger.Error("error starting MQTT ingestion", "error", err)
+	}
+
+	// A SIGHUP reloads calibration and trilateration parameters from
+	// -config without restarting, so node connections and positions survive.
+	go watchSIGHUP()
+
 	// Start a goroutine to periodically print node status
 	go func() {
 		for {
-			time.Sleep(30 * time.Second)
+			time.Sleep(currentConfig().StatusPrintInterval)
 			printNodesStatus()
 		}
 	}()
@@ -606,7 +910,17 @@ func main() {
 	http.HandleFunc("/visualization", visualizationHandler)
 	http.HandleFunc("/set-node-position", setNodePositionHandler)
 	http.HandleFunc("/calibrate", calibrationHandler)
-
-	logger.Printf("Starting trilateration server on port %d", serverPort)
-	logger.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", serverPort), nil))
+	http.HandleFunc("/kalman-config", kalmanConfigHandler)
+	http.HandleFunc("/kalman-reset", kalmanResetHandler)
+	http.HandleFunc("/events", eventsHandler)
+	http.HandleFunc("/nodes", nodesHandler)
+	http.HandleFunc("/export", exportHandler)
+	http.HandleFunc("/import", importHandler)
+	http.Handle("/metrics", metricsHandler())
+
+	logger.Info("starting trilateration server", "port", cfg.ServerPort)
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.ServerPort), nil); err != nil {
+		logger.Error("server exited", "error", err)
+		os.Exit(1)
+	}
 }